@@ -1,6 +1,10 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"strings"
@@ -152,7 +156,7 @@ func TestCountDirectory(t *testing.T) {
 
 	// Test the count method
 	bc := &bitcounter{inbuf: make([]byte, BUFF_SIZE)}
-	err = bc.count(tmpdir)
+	err = bc.count(os.DirFS(tmpdir), ".")
 	if err != nil {
 		t.Errorf("count failed: %v", err)
 	}
@@ -174,8 +178,8 @@ func TestCountDirectory(t *testing.T) {
 func TestErrorHandling(t *testing.T) {
 	t.Run("nonexistent directory", func(t *testing.T) {
 		bc := &bitcounter{inbuf: make([]byte, BUFF_SIZE)}
-		err := bc.count("/this/path/definitely/does/not/exist/anywhere")
-		// filepath.Walk returns nil but passes error to callback, which adds it to bc.errs
+		err := bc.count(os.DirFS("/this/path/definitely/does/not/exist/anywhere"), ".")
+		// fs.WalkDir returns nil but passes error to callback, which adds it to bc.errs
 		if err != nil {
 			t.Errorf("count should not return error, got: %v", err)
 		}
@@ -204,7 +208,7 @@ func TestErrorHandling(t *testing.T) {
 		}
 
 		bc := &bitcounter{inbuf: make([]byte, BUFF_SIZE)}
-		err = bc.count(tmpdir)
+		err = bc.count(os.DirFS(tmpdir), ".")
 		// The count method should not return an error for valid directory
 		if err != nil {
 			t.Errorf("count should not return error for valid directory, got: %v", err)
@@ -301,6 +305,229 @@ func TestBitCountAccuracy(t *testing.T) {
 	}
 }
 
+// mustWriteRandFile writes an n-byte file of random data under dir and
+// returns its path.
+func mustWriteRandFile(tb testing.TB, dir, name string, n int) string {
+	tb.Helper()
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		tb.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, buf, 0644); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+// BenchmarkCount scans a directory of randomly generated files to
+// demonstrate that the worker pool scales with GOMAXPROCS and to catch
+// regressions in that scaling.
+func BenchmarkCount(b *testing.B) {
+	tmpdir, err := os.MkdirTemp("", "bitcount_bench_dir")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	const numFiles = 32
+	const fileSize = 1 << 20 // 1 MiB
+	for i := 0; i < numFiles; i++ {
+		mustWriteRandFile(b, tmpdir, fmt.Sprintf("file%d.bin", i), fileSize)
+	}
+
+	b.SetBytes(int64(numFiles * fileSize))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Count(os.DirFS(tmpdir), ".", Options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// makeRandBuf returns an n-byte buffer of random data.
+func makeRandBuf(tb testing.TB, n int) []byte {
+	tb.Helper()
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		tb.Fatal(err)
+	}
+	return buf
+}
+
+// BenchmarkCountBitsPopcount and BenchmarkCountBitsKernighan compare the
+// bits.OnesCount64 fast path against the original byte-at-a-time kernel on
+// a buffer too large to fit in a single Read, to make the popcount speedup
+// visible and catch regressions in it.
+func BenchmarkCountBitsPopcount(b *testing.B) {
+	buf := makeRandBuf(b, 100<<20) // 100 MiB
+	inbuf := make([]byte, BUFF_SIZE)
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := countBitsPopcount(bytes.NewReader(buf), inbuf, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCountBitsKernighan(b *testing.B) {
+	buf := makeRandBuf(b, 100<<20) // 100 MiB
+	inbuf := make([]byte, BUFF_SIZE)
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := countBitsKernighan(bytes.NewReader(buf), inbuf, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestCountBitsAlgorithmsAgree checks that the fast popcount path and the
+// original Kernighan kernel always report the same number of set bits.
+func TestCountBitsAlgorithmsAgree(t *testing.T) {
+	buf := makeRandBuf(t, 10007) // deliberately not a multiple of 8
+	inbuf := make([]byte, BUFF_SIZE)
+
+	_, wantOnes, err := countBitsKernighan(bytes.NewReader(buf), inbuf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, gotOnes, err := countBitsPopcount(bytes.NewReader(buf), inbuf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotOnes != wantOnes {
+		t.Errorf("countBitsPopcount = %d ones, countBitsKernighan = %d ones", gotOnes, wantOnes)
+	}
+}
+
+// TestCountResultDetails checks that Count populates PerFile and Histogram
+// in addition to the totals.
+func TestCountResultDetails(t *testing.T) {
+	tmpdir, err := os.MkdirTemp("", "bitcount_result_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	if err := os.WriteFile(filepath.Join(tmpdir, "a.bin"), []byte{0xFF, 0x00}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpdir, "b.bin"), []byte{0xAA}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := Count(os.DirFS(tmpdir), ".", Options{})
+	if err != nil {
+		t.Fatalf("Count failed: %v", err)
+	}
+
+	if res.TotalBytes != 3 {
+		t.Errorf("expected 3 total bytes, got %d", res.TotalBytes)
+	}
+	if res.TotalOnes != 12 { // 8 + 0 + 4
+		t.Errorf("expected 12 total ones, got %d", res.TotalOnes)
+	}
+	if len(res.PerFile) != 2 {
+		t.Fatalf("expected 2 per-file entries, got %d", len(res.PerFile))
+	}
+	if res.Histogram[0xFF] != 1 || res.Histogram[0x00] != 1 || res.Histogram[0xAA] != 1 {
+		t.Errorf("unexpected histogram: %v", res.Histogram)
+	}
+}
+
+// TestCountIncludeExclude checks that Include/Exclude filters are applied
+// to files, and that Exclude prunes whole directories.
+func TestCountIncludeExclude(t *testing.T) {
+	tmpdir, err := os.MkdirTemp("", "bitcount_filter_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	if err := os.WriteFile(filepath.Join(tmpdir, "keep.txt"), []byte{0xFF}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpdir, "skip.bin"), []byte{0xFF, 0xFF}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	prunedDir := filepath.Join(tmpdir, "node_modules")
+	if err := os.Mkdir(prunedDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(prunedDir, "dep.txt"), []byte{0xFF, 0xFF, 0xFF}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := Count(os.DirFS(tmpdir), ".", Options{
+		Include: []string{"*.txt"},
+		Exclude: []string{"node_modules"},
+	})
+	if err != nil {
+		t.Fatalf("Count failed: %v", err)
+	}
+
+	if res.TotalBytes != 1 {
+		t.Errorf("expected only keep.txt (1 byte) to be scanned, got %d bytes", res.TotalBytes)
+	}
+	if len(res.PerFile) != 1 || res.PerFile[0].Path != "keep.txt" {
+		t.Errorf("expected only keep.txt in PerFile, got %v", res.PerFile)
+	}
+}
+
+// TestCountGitignoreAware checks that GitignoreAware excludes patterns
+// listed in a .gitignore file at the scan root.
+func TestCountGitignoreAware(t *testing.T) {
+	tmpdir, err := os.MkdirTemp("", "bitcount_gitignore_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	if err := os.WriteFile(filepath.Join(tmpdir, ".gitignore"), []byte("*.log\n# a comment\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpdir, "keep.txt"), []byte{0xFF}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpdir, "debug.log"), []byte{0xFF, 0xFF}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := Count(os.DirFS(tmpdir), ".", Options{GitignoreAware: true})
+	if err != nil {
+		t.Fatalf("Count failed: %v", err)
+	}
+
+	for _, fstat := range res.PerFile {
+		if fstat.Path == "debug.log" {
+			t.Errorf("expected debug.log to be excluded by .gitignore, but it was scanned: %v", res.PerFile)
+		}
+	}
+}
+
+// TestEntropy checks the Shannon entropy calculation against known cases.
+func TestEntropy(t *testing.T) {
+	var uniform [256]uint64
+	for i := range uniform {
+		uniform[i] = 1
+	}
+	if got := entropy(uniform, 256); math.Abs(got-8) > 1e-9 {
+		t.Errorf("expected entropy 8 for a uniform byte distribution, got %v", got)
+	}
+
+	var constant [256]uint64
+	constant[0x00] = 100
+	if got := entropy(constant, 100); got != 0 {
+		t.Errorf("expected entropy 0 for a constant byte value, got %v", got)
+	}
+
+	if got := entropy([256]uint64{}, 0); got != 0 {
+		t.Errorf("expected entropy 0 for an empty input, got %v", got)
+	}
+}
+
 // TestInitialization tests that bitcounter is properly initialized
 func TestInitialization(t *testing.T) {
 	bc := &bitcounter{inbuf: make([]byte, BUFF_SIZE)}